@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// alignedColumn is a column present (after renames) on both sides, paired up
+// by name rather than by position.
+type alignedColumn struct {
+	leftIdx, rightIdx int
+	header            string
+}
+
+// headerAlignment maps a left and right header onto each other by name, so
+// reordered, added, or removed columns don't produce noise on every row.
+type headerAlignment struct {
+	pairs     []alignedColumn
+	leftOnly  []int
+	rightOnly []int
+}
+
+// parseRenames parses "-rename old=new,old2=new2" into a left-header ->
+// right-header lookup used while aligning.
+func parseRenames(raw []string) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	renames := make(map[string]string, len(raw))
+	for _, pair := range raw {
+		old, new_, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -rename pair %q, expected old=new", pair)
+		}
+		renames[old] = new_
+	}
+	return renames, nil
+}
+
+// buildAlignment aligns left.headerToIndex and right.headerToIndex by name,
+// translating left header names through renames first so a column renamed
+// between exports still lines up.
+func buildAlignment(leftHeaderToIndex, rightHeaderToIndex map[string]int, renames map[string]string) *headerAlignment {
+	align := &headerAlignment{}
+	usedRight := make(map[int]bool, len(rightHeaderToIndex))
+
+	for name, li := range leftHeaderToIndex {
+		canonical := name
+		if renamed, ok := renames[name]; ok {
+			canonical = renamed
+		}
+		if ri, ok := rightHeaderToIndex[canonical]; ok {
+			align.pairs = append(align.pairs, alignedColumn{leftIdx: li, rightIdx: ri, header: canonical})
+			usedRight[ri] = true
+		} else {
+			align.leftOnly = append(align.leftOnly, li)
+		}
+	}
+	for name, ri := range rightHeaderToIndex {
+		if !usedRight[ri] {
+			_ = name
+			align.rightOnly = append(align.rightOnly, ri)
+		}
+	}
+
+	sort.Slice(align.pairs, func(i, j int) bool { return align.pairs[i].leftIdx < align.pairs[j].leftIdx })
+	sort.Ints(align.leftOnly)
+	sort.Ints(align.rightOnly)
+	return align
+}
+
+// cellAt returns rec[i] and true, or ("", false) if the row is too short to
+// have that column - which -fields-per-record=-1 allows.
+func cellAt(rec []string, i int) (string, bool) {
+	if i < 0 || i >= len(rec) {
+		return "", false
+	}
+	return rec[i], true
+}
+
+// reportSchemaAlignment reports, once per compareFile/streamCompare run
+// rather than once per row, which columns -align-headers could not pair up
+// across the two files. It goes through out (rather than logging directly)
+// so machine-readable formats see these as events too, not just text.
+func reportSchemaAlignment(align *headerAlignment, leftIndexToHeader, rightIndexToHeader map[int]string, policy *columnPolicy, out diffWriter) {
+	for _, li := range align.leftOnly {
+		if policy.included(li) {
+			out.OneSidedColumn("left", leftIndexToHeader[li])
+		}
+	}
+	for _, ri := range align.rightOnly {
+		if policy.included(ri) {
+			out.OneSidedColumn("right", rightIndexToHeader[ri])
+		}
+	}
+}
+
+// diffAlignedFields compares leftRec and rightRec by aligned column rather
+// than by position. A row shorter than its header (permitted by
+// -fields-per-record=-1) can't have every aligned cell read from it; such a
+// row is reported as incompatible instead of panicking or being silently
+// compared against zero-value cells.
+func diffAlignedFields(
+	leftRec, rightRec []string, align *headerAlignment, modifiedFields map[string]int, policy *columnPolicy,
+) (changes []fieldChange, incompatible bool) {
+	for _, p := range align.pairs {
+		if !policy.included(p.leftIdx) {
+			continue
+		}
+		lv, lok := cellAt(leftRec, p.leftIdx)
+		rv, rok := cellAt(rightRec, p.rightIdx)
+		if !lok || !rok {
+			return nil, true
+		}
+		if policy.equal(p.leftIdx, lv, rv) {
+			continue
+		}
+		changes = append(changes, fieldChange{
+			leftHeader:  p.header,
+			rightHeader: p.header,
+			from:        lv,
+			to:          rv,
+		})
+		modifiedFields[p.header]++
+	}
+	return changes, false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the layouts tried, in order, when parsing a -date-cols
+// value. Real-world exports mix zero-padded and unpadded dates, so both
+// "2006-01-02" and "2006-1-2" style inputs are accepted.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-1-2",
+	"2006/01/02",
+	"2006/1/2",
+	time.RFC3339,
+}
+
+// columnPolicy decides, per column index, whether a column is compared at
+// all and how its values are compared.
+type columnPolicy struct {
+	ignore      map[int]bool
+	compareOnly map[int]bool // nil/empty means "compare everything not ignored"
+	numeric     map[int]bool
+	date        map[int]bool
+}
+
+// newColumnPolicy resolves the -ignore-cols/-compare-cols/-numeric-cols/
+// -date-cols header names against headerToIndex.
+func newColumnPolicy(cfg *csvConfig, headerToIndex map[string]int) (*columnPolicy, error) {
+	resolve := func(names []string) (map[int]bool, error) {
+		if len(names) == 0 {
+			return nil, nil
+		}
+		set := make(map[int]bool, len(names))
+		for _, name := range names {
+			idx, ok := headerToIndex[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown column %q", name)
+			}
+			set[idx] = true
+		}
+		return set, nil
+	}
+
+	ignore, err := resolve(cfg.ignoreCols)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve -ignore-cols: %w", err)
+	}
+	compareOnly, err := resolve(cfg.compareCols)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve -compare-cols: %w", err)
+	}
+	numeric, err := resolve(cfg.numericCols)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve -numeric-cols: %w", err)
+	}
+	date, err := resolve(cfg.dateCols)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve -date-cols: %w", err)
+	}
+
+	return &columnPolicy{ignore: ignore, compareOnly: compareOnly, numeric: numeric, date: date}, nil
+}
+
+// included reports whether column i should be compared at all.
+func (p *columnPolicy) included(i int) bool {
+	if p == nil {
+		return true
+	}
+	if p.ignore[i] {
+		return false
+	}
+	if len(p.compareOnly) > 0 && !p.compareOnly[i] {
+		return false
+	}
+	return true
+}
+
+// equal reports whether two raw values in column i should be treated as
+// equal, applying numeric/date-aware comparison where configured.
+func (p *columnPolicy) equal(i int, a, b string) bool {
+	if a == b {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	if p.numeric[i] {
+		if af, aErr := strconv.ParseFloat(strings.TrimSpace(a), 64); aErr == nil {
+			if bf, bErr := strconv.ParseFloat(strings.TrimSpace(b), 64); bErr == nil {
+				return af == bf
+			}
+		}
+	}
+	if p.date[i] {
+		if at, ok := parseDate(a); ok {
+			if bt, ok := parseDate(b); ok {
+				return at.Equal(bt)
+			}
+		}
+	}
+	return false
+}
+
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
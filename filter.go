@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// whereExpr is a compiled -where predicate, evaluated against a record using
+// the file's header-name -> index mapping.
+type whereExpr interface {
+	eval(rec []string, headerToIndex map[string]int) (bool, error)
+}
+
+type andExpr struct{ left, right whereExpr }
+type orExpr struct{ left, right whereExpr }
+type notExpr struct{ inner whereExpr }
+
+func (e *andExpr) eval(rec []string, h map[string]int) (bool, error) {
+	l, err := e.left.eval(rec, h)
+	if err != nil || !l {
+		return false, err
+	}
+	return e.right.eval(rec, h)
+}
+
+func (e *orExpr) eval(rec []string, h map[string]int) (bool, error) {
+	l, err := e.left.eval(rec, h)
+	if err != nil || l {
+		return l, err
+	}
+	return e.right.eval(rec, h)
+}
+
+func (e *notExpr) eval(rec []string, h map[string]int) (bool, error) {
+	v, err := e.inner.eval(rec, h)
+	return !v, err
+}
+
+// cmpExpr compares one header-named field against a literal.
+type cmpExpr struct {
+	field    string
+	op       string // "=", "!=", "<", ">", "~"
+	value    string
+	isNumber bool
+	num      float64
+	re       *regexp.Regexp
+}
+
+func (e *cmpExpr) eval(rec []string, h map[string]int) (bool, error) {
+	idx, ok := h[e.field]
+	if !ok {
+		return false, fmt.Errorf("unknown field %q in -where expression", e.field)
+	}
+	fv := rec[idx]
+
+	if e.op == "~" {
+		return e.re.MatchString(fv), nil
+	}
+
+	if e.op == "<" || e.op == ">" {
+		fn, err := strconv.ParseFloat(strings.TrimSpace(fv), 64)
+		if err != nil {
+			return false, fmt.Errorf("can't compare non-numeric field %q value %q with %s", e.field, fv, e.op)
+		}
+		if e.op == "<" {
+			return fn < e.num, nil
+		}
+		return fn > e.num, nil
+	}
+
+	var equal bool
+	if e.isNumber {
+		if fn, err := strconv.ParseFloat(strings.TrimSpace(fv), 64); err == nil {
+			equal = fn == e.num
+		} else {
+			equal = fv == e.value
+		}
+	} else {
+		equal = fv == e.value
+	}
+	if e.op == "!=" {
+		return !equal, nil
+	}
+	return equal, nil
+}
+
+// whereTokenizer splits a -where expression into the tokens the parser
+// below consumes: identifiers, quoted strings, numbers, operators and
+// parens. AND/OR/NOT are recognized case-insensitively as keywords.
+type whereTokenizer struct {
+	src []rune
+	pos int
+}
+
+func newWhereTokenizer(s string) *whereTokenizer {
+	return &whereTokenizer{src: []rune(s)}
+}
+
+func (t *whereTokenizer) skipSpace() {
+	for t.pos < len(t.src) && t.src[t.pos] == ' ' {
+		t.pos++
+	}
+}
+
+func (t *whereTokenizer) peek() (rune, bool) {
+	t.skipSpace()
+	if t.pos >= len(t.src) {
+		return 0, false
+	}
+	return t.src[t.pos], true
+}
+
+// next returns the next token, or "" at end of input.
+func (t *whereTokenizer) next() (string, error) {
+	t.skipSpace()
+	if t.pos >= len(t.src) {
+		return "", nil
+	}
+	c := t.src[t.pos]
+
+	switch {
+	case c == '(' || c == ')':
+		t.pos++
+		return string(c), nil
+	case c == '"' || c == '\'':
+		quote := c
+		t.pos++
+		start := t.pos
+		for t.pos < len(t.src) && t.src[t.pos] != quote {
+			t.pos++
+		}
+		if t.pos >= len(t.src) {
+			return "", fmt.Errorf("unterminated string literal")
+		}
+		s := string(t.src[start:t.pos])
+		t.pos++
+		return `"` + s, nil // leading quote tags this token as a string literal
+	case c == '!' && t.pos+1 < len(t.src) && t.src[t.pos+1] == '=':
+		t.pos += 2
+		return "!=", nil
+	case c == '=' || c == '<' || c == '>' || c == '~':
+		t.pos++
+		return string(c), nil
+	default:
+		start := t.pos
+		for t.pos < len(t.src) && !strings.ContainsRune(" ()=<>~!", t.src[t.pos]) {
+			t.pos++
+		}
+		if t.pos == start {
+			return "", fmt.Errorf("unexpected character %q", c)
+		}
+		return string(t.src[start:t.pos]), nil
+	}
+}
+
+// whereParser is a small recursive-descent parser for the -where grammar:
+//
+//	expr   := or
+//	or     := and (OR and)*
+//	and    := unary (AND unary)*
+//	unary  := NOT unary | '(' expr ')' | cmp
+//	cmp    := IDENT op value
+//	op     := '=' | '!=' | '<' | '>' | '~'
+//	value  := STRING | NUMBER
+type whereParser struct {
+	tok *whereTokenizer
+	cur string
+}
+
+// parseWhere compiles a -where expression such as
+// `status="active" AND amount>0` into an evaluable whereExpr.
+func parseWhere(expr string) (whereExpr, error) {
+	p := &whereParser{tok: newWhereTokenizer(expr)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.cur != "" {
+		return nil, fmt.Errorf("unexpected trailing token %q in -where expression", p.cur)
+	}
+	return e, nil
+}
+
+func (p *whereParser) advance() error {
+	tok, err := p.tok.next()
+	if err != nil {
+		return err
+	}
+	p.cur = tok
+	return nil
+}
+
+func (p *whereParser) parseOr() (whereExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.cur, "OR") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (whereExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.cur, "AND") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseUnary() (whereExpr, error) {
+	if strings.EqualFold(p.cur, "NOT") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{inner: inner}, nil
+	}
+	if p.cur == "(" {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.cur != ")" {
+			return nil, fmt.Errorf("expected ')' in -where expression")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *whereParser) parseCmp() (whereExpr, error) {
+	if p.cur == "" {
+		return nil, fmt.Errorf("unexpected end of -where expression")
+	}
+	field := p.cur
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	op := p.cur
+	switch op {
+	case "=", "!=", "<", ">", "~":
+	default:
+		return nil, fmt.Errorf("expected comparison operator after %q, got %q", field, op)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	raw := p.cur
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	e := &cmpExpr{field: field, op: op}
+	if strings.HasPrefix(raw, `"`) {
+		e.value = strings.TrimPrefix(raw, `"`)
+	} else if n, err := strconv.ParseFloat(raw, 64); err == nil {
+		e.isNumber = true
+		e.num = n
+		e.value = raw
+	} else {
+		e.value = raw
+	}
+	if op == "~" {
+		re, err := regexp.Compile(e.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q in -where expression: %w", e.value, err)
+		}
+		e.re = re
+	}
+	if (op == "<" || op == ">") && !e.isNumber {
+		return nil, fmt.Errorf("%s requires a numeric literal, got %q", op, raw)
+	}
+
+	return e, nil
+}
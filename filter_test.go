@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func evalWhere(t *testing.T, expr string, rec []string, headerToIndex map[string]int) bool {
+	t.Helper()
+	e, err := parseWhere(expr)
+	if err != nil {
+		t.Fatalf("parseWhere(%q): %v", expr, err)
+	}
+	ok, err := e.eval(rec, headerToIndex)
+	if err != nil {
+		t.Fatalf("eval(%q): %v", expr, err)
+	}
+	return ok
+}
+
+func TestParseWhereComparisons(t *testing.T) {
+	header := map[string]int{"status": 0, "amount": 1, "name": 2}
+	rec := []string{"active", "12.50", "Alice"}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`status="active"`, true},
+		{`status="inactive"`, false},
+		{`status!="inactive"`, true},
+		{`amount>10`, true},
+		{`amount<10`, false},
+		{`name~"^Al"`, true},
+		{`name~"^Bo"`, false},
+		{`status="active" AND amount>10`, true},
+		{`status="active" AND amount>100`, false},
+		{`status="inactive" OR amount>10`, true},
+		{`NOT status="inactive"`, true},
+		{`(status="active" AND amount<10) OR name="Alice"`, true},
+	}
+	for _, c := range cases {
+		if got := evalWhere(t, c.expr, rec, header); got != c.want {
+			t.Errorf("eval(%q) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseWhereNumericStringCoercion(t *testing.T) {
+	header := map[string]int{"amount": 0}
+
+	// A numeric literal compares numerically when the field parses as a
+	// number, even if the textual representations differ.
+	if !evalWhere(t, `amount=1`, []string{"1.0"}, header) {
+		t.Error(`expected amount=1 to match "1.0" via numeric coercion`)
+	}
+	// A field that doesn't parse as a number falls back to exact string
+	// comparison against the literal's raw text.
+	if evalWhere(t, `amount=1`, []string{"one"}, header) {
+		t.Error(`expected amount=1 not to match non-numeric field "one"`)
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	cases := []string{
+		`status="active`,        // unterminated string literal
+		`amount<"abc"`,          // < requires a numeric literal
+		`status`,                // missing operator
+		`status="active" extra`, // trailing token
+		`status!active`,         // bare '!' is not a valid token
+	}
+	for _, expr := range cases {
+		if _, err := parseWhere(expr); err == nil {
+			t.Errorf("parseWhere(%q): expected error, got nil", expr)
+		}
+	}
+}
+
+func TestParseWhereUnknownField(t *testing.T) {
+	e, err := parseWhere(`missing="x"`)
+	if err != nil {
+		t.Fatalf("parseWhere: %v", err)
+	}
+	if _, err := e.eval([]string{"a"}, map[string]int{"known": 0}); err == nil {
+		t.Error("expected an error evaluating an unknown field")
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
-	"bufio"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
+// keySep joins individual field values into a single composite-key string.
+// It uses a control character so it practically never collides with real
+// field content.
+const keySep = "\x1f"
+
 type csvFile struct {
 	headerToIndex map[string]int
 	indexToHeader map[int]string
@@ -21,7 +27,106 @@ type csvFile struct {
 type csvConfig struct {
 	hasHeader bool
 	comma     rune
-	idField   int
+	idFields  []string
+
+	ignoreCols  []string
+	compareCols []string
+	numericCols []string
+	dateCols    []string
+
+	lazyQuotes      bool
+	fieldsPerRecord int
+	quote           rune
+	encoding        string
+
+	alignHeaders bool
+	renames      map[string]string
+
+	where whereExpr
+}
+
+// resolveIDFields turns the raw `-id` tokens (1-based indices or header
+// names) into 0-based column indices for a specific file's header.
+func resolveIDFields(cfg *csvConfig, headerToIndex map[string]int) ([]int, error) {
+	fields := make([]int, len(cfg.idFields))
+	for i, tok := range cfg.idFields {
+		if n, err := strconv.Atoi(tok); err == nil {
+			fields[i] = n - 1
+			continue
+		}
+		idx, ok := headerToIndex[tok]
+		if !ok {
+			return nil, fmt.Errorf("unknown id field %q: not a column index and no such header", tok)
+		}
+		fields[i] = idx
+	}
+	return fields, nil
+}
+
+// recordKey builds the composite identity of a record from the given
+// (0-based) field indices, joined so it can be used as a map key. Records
+// shorter than an id field - permitted by -fields-per-record=-1 - are
+// reported as an error instead of panicking.
+func recordKey(rec []string, idFields []int) (string, error) {
+	for _, f := range idFields {
+		if f < 0 || f >= len(rec) {
+			return "", fmt.Errorf("id field %d is out of range for a record with %d field(s)", f+1, len(rec))
+		}
+	}
+	if len(idFields) == 1 {
+		return rec[idFields[0]], nil
+	}
+	parts := make([]string, len(idFields))
+	for i, f := range idFields {
+		parts[i] = rec[f]
+	}
+	return strings.Join(parts, keySep), nil
+}
+
+// formatKey renders a composite key for human-readable diff messages,
+// e.g. "1" for a single field or "(1, 3)" for a composite one.
+func formatKey(id string) string {
+	if !strings.Contains(id, keySep) {
+		return id
+	}
+	return "(" + strings.Join(strings.Split(id, keySep), ", ") + ")"
+}
+
+// splitList splits a comma-separated flag value into its tokens, returning
+// nil for an empty string instead of a slice containing a single "" token.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// diffRecordFields compares two same-length records field by field and
+// returns the changed columns, tallying them into modifiedFields. It is
+// shared by the in-memory and streaming comparison paths.
+func diffRecordFields(leftRec, rightRec []string, leftIndexToHeader, rightIndexToHeader map[int]string, modifiedFields map[string]int, policy *columnPolicy) []fieldChange {
+	var changes []fieldChange
+	for i, s := range rightRec {
+		if !policy.included(i) {
+			continue
+		}
+		if !policy.equal(i, leftRec[i], s) {
+			leftHeader := leftIndexToHeader[i]
+			rightHeader := rightIndexToHeader[i]
+			changes = append(changes, fieldChange{
+				leftHeader:  leftHeader,
+				rightHeader: rightHeader,
+				from:        leftRec[i],
+				to:          s,
+			})
+			if leftHeader == rightHeader {
+				modifiedFields[leftHeader]++
+			} else {
+				modifiedFields[fmt.Sprintf("%s - %s", leftHeader, rightHeader)]++
+			}
+		}
+	}
+	return changes
 }
 
 func loadFile(cfg *csvConfig, name string, readAll bool) (*csvFile, *csv.Reader, error) {
@@ -30,8 +135,10 @@ func loadFile(cfg *csvConfig, name string, readAll bool) (*csvFile, *csv.Reader,
 		log.Fatalf("Can't open file %q: %s", name, err)
 	}
 
-	r := csv.NewReader(bufio.NewReader(f))
-	r.Comma = cfg.comma
+	r, err := newCSVReader(cfg, f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't set up CSV reader for file %q: %w", name, err)
+	}
 
 	var headerToIndex map[string]int
 	var indexToHeader map[int]string
@@ -48,16 +155,38 @@ func loadFile(cfg *csvConfig, name string, readAll bool) (*csvFile, *csv.Reader,
 		}
 	}
 
+	idFields, err := resolveIDFields(cfg, headerToIndex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't resolve id fields for file %q: %w", name, err)
+	}
+
 	var records [][]string
 	var index map[string]int
 	if readAll {
 		index = make(map[string]int)
-		records, err = r.ReadAll()
-		if err != nil {
-			return nil, nil, fmt.Errorf("can't read records from file %q: %w", name, err)
-		}
-		for i, record := range records {
-			index[record[cfg.idField]] = i
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't read records from file %q: %w", name, err)
+			}
+			if cfg.where != nil {
+				match, err := cfg.where.eval(record, headerToIndex)
+				if err != nil {
+					return nil, nil, fmt.Errorf("can't evaluate -where against file %q: %w", name, err)
+				}
+				if !match {
+					continue
+				}
+			}
+			key, err := recordKey(record, idFields)
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't key record in file %q: %w", name, err)
+			}
+			index[key] = len(records)
+			records = append(records, record)
 		}
 	}
 
@@ -69,12 +198,30 @@ func loadFile(cfg *csvConfig, name string, readAll bool) (*csvFile, *csv.Reader,
 	}, r, nil
 }
 
-func compareFile(cfg *csvConfig, path string, left *csvFile) error {
+func compareFile(cfg *csvConfig, path string, left *csvFile, out diffWriter) error {
 	right, reader, err := loadFile(cfg, path, false)
 	if err != nil {
 		return err
 	}
 
+	leftIDFields, err := resolveIDFields(cfg, left.headerToIndex)
+	if err != nil {
+		return fmt.Errorf("can't resolve id fields for left file: %w", err)
+	}
+	rightIDFields, err := resolveIDFields(cfg, right.headerToIndex)
+	if err != nil {
+		return fmt.Errorf("can't resolve id fields for file %q: %w", path, err)
+	}
+	policy, err := newColumnPolicy(cfg, left.headerToIndex)
+	if err != nil {
+		return err
+	}
+	var align *headerAlignment
+	if cfg.alignHeaders {
+		align = buildAlignment(left.headerToIndex, right.headerToIndex, cfg.renames)
+		reportSchemaAlignment(align, left.indexToHeader, right.indexToHeader, policy, out)
+	}
+
 	reader.ReuseRecord = true
 	var count int = 0
 	processedIds := make(map[string]bool)
@@ -89,65 +236,105 @@ func compareFile(cfg *csvConfig, path string, left *csvFile) error {
 		if err != nil {
 			return fmt.Errorf("can't read record from file %q: %w", path, err)
 		}
-		id := rec[cfg.idField]
+		if cfg.where != nil {
+			match, err := cfg.where.eval(rec, right.headerToIndex)
+			if err != nil {
+				return fmt.Errorf("can't evaluate -where against file %q: %w", path, err)
+			}
+			if !match {
+				continue
+			}
+		}
+		id, err := recordKey(rec, rightIDFields)
+		if err != nil {
+			return fmt.Errorf("can't key record in file %q: %w", path, err)
+		}
 		processedIds[id] = true
 		idx, ok := left.index[id]
 		if !ok {
 			addedRecords++
-			log.Printf("Added record #%d with ID = %q\n", count+1, id)
+			out.Added(count+1, id)
 		} else {
 			leftRec := left.rows[idx]
-			if len(leftRec) != len(rec) {
-				fmt.Printf(
-					"Incompatible record #%d - #%d with ID = %q (%d - %d)\n", idx+1, count+1, id, len(leftRec),
-					len(rec),
-				)
-			} else {
-				message := ""
-				for i, s := range rec {
-					if leftRec[i] != s {
-						if message == "" {
-							message = fmt.Sprintf("Changed records #%d - #%d with ID = %q:\n", idx+1, count+1, id)
-						}
-						leftHeader := left.indexToHeader[i]
-						rightHeader := right.indexToHeader[i]
-						if leftHeader == rightHeader {
-							message += fmt.Sprintf("    %q: %q - %q\n", leftHeader, leftRec[i], s)
-							modifiedFields[leftHeader]++
-						} else {
-							message += fmt.Sprintf("    %q: %q - %q: %q\n", leftHeader, leftRec[i], rightHeader, s)
-							modifiedFields[fmt.Sprintf("%s - %s", leftHeader, rightHeader)]++
-						}
-
-					}
-				}
-				if message != "" {
-					fmt.Print(message)
+			var changes []fieldChange
+			if align != nil {
+				var incompatible bool
+				changes, incompatible = diffAlignedFields(leftRec, rec, align, modifiedFields, policy)
+				if incompatible {
+					out.Incompatible(idx+1, count+1, id, len(leftRec), len(rec))
 				}
+			} else if len(leftRec) != len(rec) {
+				out.Incompatible(idx+1, count+1, id, len(leftRec), len(rec))
+			} else {
+				changes = diffRecordFields(leftRec, rec, left.indexToHeader, right.indexToHeader, modifiedFields, policy)
+			}
+			if len(changes) > 0 {
+				out.Modified(idx+1, count+1, id, changes)
 			}
 		}
 		count++
 	}
 	for idx, rec := range left.rows {
-		id := rec[cfg.idField]
+		id, err := recordKey(rec, leftIDFields)
+		if err != nil {
+			return fmt.Errorf("can't key left record: %w", err)
+		}
 		if !processedIds[id] {
 			removedRecords++
-			fmt.Printf("Removed record #%d with ID = %q\n", idx+1, id)
+			out.Removed(idx+1, id)
 		}
 	}
-	fmt.Println("-----------------------------------------------------------")
-	fmt.Printf("Added %d records\n", addedRecords)
-	fmt.Printf("Removed %d records\n", removedRecords)
-	fmt.Printf("Changed fields:\n")
-	for k, v := range modifiedFields {
-		fmt.Printf("    %q: %d\n", k, v)
-	}
-	return nil
+	return out.Summary(addedRecords, removedRecords, modifiedFields)
 }
 
 func main() {
 	commaFlag := flag.String("sep", "|", "CSV file separator")
-	idFlag := flag.Int("id", 1, "1-based field index used to uniquely identify CSV record")
+	idFlag := flag.String(
+		"id", "1",
+		"comma-separated list of 1-based field indices or header names used to uniquely identify a CSV record, e.g. -id 1,3 or -id order_id,line_no",
+	)
+	formatFlag := flag.String("format", "text", "output format: text, json, or csv-patch")
+	assumeSortedFlag := flag.Bool(
+		"assume-sorted", false,
+		"treat both inputs as already sorted by id in non-decreasing byte/lexical order (e.g. by `sort`, not numeric order for numeric ids) and merge-join them in lockstep instead of buffering the left file in memory; an out-of-order id is reported as an error",
+	)
+	externalSortFlag := flag.Bool(
+		"external-sort", false,
+		"diff unsorted inputs of any size by spilling sorted runs to disk and merge-joining them, instead of buffering the left file in memory",
+	)
+	ignoreColsFlag := flag.String("ignore-cols", "", "comma-separated header names to exclude from comparison")
+	compareColsFlag := flag.String("compare-cols", "", "comma-separated header names to exclusively compare, ignoring all others")
+	numericColsFlag := flag.String(
+		"numeric-cols", "", "comma-separated header names compared as numbers, so \"1.0\" and \"1.00\" are equal",
+	)
+	dateColsFlag := flag.String(
+		"date-cols", "", "comma-separated header names compared as dates, so \"2024-01-01\" and \"2024-1-1\" are equal",
+	)
+	lazyQuotesFlag := flag.Bool("lazy-quotes", false, "relax CSV quote parsing to accept bare quotes inside unquoted fields")
+	// Default -1 (variable widths accepted) relies on every row-length-sensitive
+	// path - recordKey, diffAlignedFields, and diffRecordFields's equal-length
+	// precondition - handling a short row as a clean error/Incompatible result
+	// rather than an out-of-range panic. If that invariant ever regresses,
+	// flip this default back to 0 rather than re-auditing every call site.
+	fieldsPerRecordFlag := flag.Int(
+		"fields-per-record", -1,
+		"number of fields each record must have; -1 accepts variable widths, 0 infers it from the first record",
+	)
+	quoteFlag := flag.String("quote", "", "custom single-byte quote character, if the input doesn't use \"")
+	encodingFlag := flag.String(
+		"encoding", "utf8", "source character encoding: utf8, gbk, shift-jis, latin1, or utf16",
+	)
+	alignHeadersFlag := flag.Bool(
+		"align-headers", false,
+		"align left and right columns by header name instead of position, so reordered/added/removed columns don't produce noise on every row",
+	)
+	renameFlag := flag.String(
+		"rename", "", "comma-separated old=new header renames applied to the left file before -align-headers aligns columns",
+	)
+	whereFlag := flag.String(
+		"where", "",
+		`expression filtering rows before they're diffed, e.g. -where 'status="active" AND amount>0'; supports =, !=, <, >, ~ (regex), AND, OR, NOT`,
+	)
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, "Usage of %s: [flags] [left path] [right path]\n\nFlags:\n", os.Args[0])
 
@@ -162,20 +349,57 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
+	var quote rune
+	if *quoteFlag != "" {
+		quote = []rune(*quoteFlag)[0]
+	}
+	renames, err := parseRenames(splitList(*renameFlag))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var where whereExpr
+	if *whereFlag != "" {
+		where, err = parseWhere(*whereFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 	cfg := csvConfig{
-		hasHeader: true,
-		comma:     []rune(*commaFlag)[0],
-		idField:   *idFlag - 1,
+		hasHeader:       true,
+		comma:           []rune(*commaFlag)[0],
+		idFields:        strings.Split(*idFlag, ","),
+		ignoreCols:      splitList(*ignoreColsFlag),
+		compareCols:     splitList(*compareColsFlag),
+		numericCols:     splitList(*numericColsFlag),
+		dateCols:        splitList(*dateColsFlag),
+		lazyQuotes:      *lazyQuotesFlag,
+		fieldsPerRecord: *fieldsPerRecordFlag,
+		quote:           quote,
+		encoding:        *encodingFlag,
+		alignHeaders:    *alignHeadersFlag,
+		renames:         renames,
+		where:           where,
 	}
 	leftName := args[0]
 	rightName := args[1]
 
-	left, _, err := loadFile(&cfg, leftName, true)
+	out, err := newDiffWriter(*formatFlag, os.Stdout)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	err = compareFile(&cfg, rightName, left)
+	switch {
+	case *externalSortFlag:
+		err = compareFileStreaming(&cfg, modeExternalSort, leftName, rightName, out)
+	case *assumeSortedFlag:
+		err = compareFileStreaming(&cfg, modeSorted, leftName, rightName, out)
+	default:
+		var left *csvFile
+		left, _, err = loadFile(&cfg, leftName, true)
+		if err == nil {
+			err = compareFile(&cfg, rightName, left, out)
+		}
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// fieldChange describes a single changed column between the left and right
+// records of a modified row.
+type fieldChange struct {
+	leftHeader  string
+	rightHeader string
+	from        string
+	to          string
+}
+
+// diffWriter receives diff events from compareFile and renders them in a
+// particular output format. Implementations must tolerate being driven
+// purely by id/row-number information - compareFile holds the only
+// references to the underlying records.
+type diffWriter interface {
+	Added(rowNum int, id string)
+	Removed(rowNum int, id string)
+	Incompatible(leftRowNum, rightRowNum int, id string, leftLen, rightLen int)
+	Modified(leftRowNum, rightRowNum int, id string, changes []fieldChange)
+	// OneSidedColumn reports, once per run, a -align-headers column present
+	// in only one file and therefore excluded from every row's comparison.
+	// side is "left" or "right".
+	OneSidedColumn(side, header string)
+	Summary(added, removed int, modifiedFields map[string]int) error
+}
+
+// newDiffWriter builds the diffWriter for the requested output format.
+func newDiffWriter(format string, w io.Writer) (diffWriter, error) {
+	switch format {
+	case "", "text":
+		return &textDiffWriter{w: w}, nil
+	case "json":
+		return &jsonDiffWriter{enc: json.NewEncoder(w)}, nil
+	case "csv-patch":
+		return newCSVPatchWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// textDiffWriter reproduces the original human-readable log output.
+type textDiffWriter struct {
+	w io.Writer
+}
+
+func (t *textDiffWriter) Added(rowNum int, id string) {
+	fmt.Fprintf(t.w, "Added record #%d with ID = %q\n", rowNum, formatKey(id))
+}
+
+func (t *textDiffWriter) Removed(rowNum int, id string) {
+	fmt.Fprintf(t.w, "Removed record #%d with ID = %q\n", rowNum, formatKey(id))
+}
+
+func (t *textDiffWriter) Incompatible(leftRowNum, rightRowNum int, id string, leftLen, rightLen int) {
+	fmt.Fprintf(
+		t.w, "Incompatible record #%d - #%d with ID = %q (%d - %d)\n", leftRowNum, rightRowNum, formatKey(id), leftLen,
+		rightLen,
+	)
+}
+
+func (t *textDiffWriter) Modified(leftRowNum, rightRowNum int, id string, changes []fieldChange) {
+	message := fmt.Sprintf("Changed records #%d - #%d with ID = %q:\n", leftRowNum, rightRowNum, formatKey(id))
+	for _, c := range changes {
+		if c.leftHeader == c.rightHeader {
+			message += fmt.Sprintf("    %q: %q - %q\n", c.leftHeader, c.from, c.to)
+		} else {
+			message += fmt.Sprintf("    %q: %q - %q: %q\n", c.leftHeader, c.from, c.rightHeader, c.to)
+		}
+	}
+	fmt.Fprint(t.w, message)
+}
+
+func (t *textDiffWriter) OneSidedColumn(side, header string) {
+	fmt.Fprintf(t.w, "Column %q present only in %s file, excluded from comparison\n", header, side)
+}
+
+func (t *textDiffWriter) Summary(added, removed int, modifiedFields map[string]int) error {
+	fmt.Fprintln(t.w, "-----------------------------------------------------------")
+	fmt.Fprintf(t.w, "Added %d records\n", added)
+	fmt.Fprintf(t.w, "Removed %d records\n", removed)
+	fmt.Fprintf(t.w, "Changed fields:\n")
+	for k, v := range modifiedFields {
+		fmt.Fprintf(t.w, "    %q: %d\n", k, v)
+	}
+	return nil
+}
+
+// jsonDiffWriter emits one JSON object per change, followed by a final
+// summary object, all newline-delimited so the stream can be consumed
+// incrementally by CI tooling.
+type jsonDiffWriter struct {
+	enc *json.Encoder
+}
+
+type jsonFieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type jsonDiffEvent struct {
+	Op      string                     `json:"op"`
+	ID      interface{}                `json:"id"`
+	Changes map[string]jsonFieldChange `json:"changes,omitempty"`
+}
+
+// jsonKey renders a record id for JSON: a plain string for a single-column
+// key, or an array of its parts for a composite one (chunk0-1's `-id
+// a,b` keys), so consumers don't have to split on the internal keySep
+// control character themselves.
+func jsonKey(id string) interface{} {
+	if !strings.Contains(id, keySep) {
+		return id
+	}
+	return strings.Split(id, keySep)
+}
+
+type jsonSummary struct {
+	Summary        bool           `json:"summary"`
+	Added          int            `json:"added"`
+	Removed        int            `json:"removed"`
+	ModifiedFields map[string]int `json:"modified_fields"`
+}
+
+func (j *jsonDiffWriter) Added(rowNum int, id string) {
+	_ = j.enc.Encode(jsonDiffEvent{Op: "add", ID: jsonKey(id)})
+}
+
+func (j *jsonDiffWriter) Removed(rowNum int, id string) {
+	_ = j.enc.Encode(jsonDiffEvent{Op: "remove", ID: jsonKey(id)})
+}
+
+func (j *jsonDiffWriter) Incompatible(leftRowNum, rightRowNum int, id string, leftLen, rightLen int) {
+	_ = j.enc.Encode(jsonDiffEvent{
+		Op: "incompatible",
+		ID: jsonKey(id),
+		Changes: map[string]jsonFieldChange{
+			"_length": {From: fmt.Sprint(leftLen), To: fmt.Sprint(rightLen)},
+		},
+	})
+}
+
+func (j *jsonDiffWriter) Modified(leftRowNum, rightRowNum int, id string, changes []fieldChange) {
+	cols := make(map[string]jsonFieldChange, len(changes))
+	for _, c := range changes {
+		col := c.leftHeader
+		if c.leftHeader != c.rightHeader {
+			col = fmt.Sprintf("%s -> %s", c.leftHeader, c.rightHeader)
+		}
+		cols[col] = jsonFieldChange{From: c.from, To: c.to}
+	}
+	_ = j.enc.Encode(jsonDiffEvent{Op: "modify", ID: jsonKey(id), Changes: cols})
+}
+
+type jsonSchemaEvent struct {
+	Op     string `json:"op"`
+	Side   string `json:"side"`
+	Column string `json:"column"`
+}
+
+func (j *jsonDiffWriter) OneSidedColumn(side, header string) {
+	_ = j.enc.Encode(jsonSchemaEvent{Op: "one_sided_column", Side: side, Column: header})
+}
+
+func (j *jsonDiffWriter) Summary(added, removed int, modifiedFields map[string]int) error {
+	return j.enc.Encode(jsonSummary{Summary: true, Added: added, Removed: removed, ModifiedFields: modifiedFields})
+}
+
+// csvPatchWriter emits a CSV patch: one row per changed column, with enough
+// information (op, id, column, old_value, new_value) to apply the diff back
+// programmatically.
+type csvPatchWriter struct {
+	w *csv.Writer
+}
+
+func newCSVPatchWriter(w io.Writer) *csvPatchWriter {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"op", "id", "column", "old_value", "new_value"})
+	return &csvPatchWriter{w: cw}
+}
+
+func (c *csvPatchWriter) Added(rowNum int, id string) {
+	_ = c.w.Write([]string{"add", formatKey(id), "", "", ""})
+}
+
+func (c *csvPatchWriter) Removed(rowNum int, id string) {
+	_ = c.w.Write([]string{"remove", formatKey(id), "", "", ""})
+}
+
+func (c *csvPatchWriter) Incompatible(leftRowNum, rightRowNum int, id string, leftLen, rightLen int) {
+	_ = c.w.Write([]string{"incompatible", formatKey(id), "", fmt.Sprint(leftLen), fmt.Sprint(rightLen)})
+}
+
+func (c *csvPatchWriter) Modified(leftRowNum, rightRowNum int, id string, changes []fieldChange) {
+	for _, ch := range changes {
+		col := ch.leftHeader
+		if ch.leftHeader != ch.rightHeader {
+			col = fmt.Sprintf("%s -> %s", ch.leftHeader, ch.rightHeader)
+		}
+		_ = c.w.Write([]string{"modify", formatKey(id), col, ch.from, ch.to})
+	}
+}
+
+func (c *csvPatchWriter) OneSidedColumn(side, header string) {
+	_ = c.w.Write([]string{"one_sided_column", "", header, side, ""})
+}
+
+func (c *csvPatchWriter) Summary(added, removed int, modifiedFields map[string]int) error {
+	c.w.Flush()
+	return c.w.Error()
+}
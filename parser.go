@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// decoderFor resolves a -encoding flag value to its x/text decoder. An
+// empty name or "utf8" returns a nil decoder, meaning no transcoding.
+func decoderFor(name string) (*encoding.Decoder, error) {
+	switch strings.ToLower(name) {
+	case "", "utf8", "utf-8":
+		return nil, nil
+	case "gbk":
+		return simplifiedchinese.GBK.NewDecoder(), nil
+	case "shift-jis", "shiftjis", "sjis":
+		return japanese.ShiftJIS.NewDecoder(), nil
+	case "latin1", "iso-8859-1":
+		return charmap.ISO8859_1.NewDecoder(), nil
+	case "utf16":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder(), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+}
+
+// quoteSwapReader lets encoding/csv - which hard-codes '"' as its quote
+// character - parse files quoted with a different, single-byte character by
+// swapping it for '"' (and any literal '"' for the configured character) as
+// bytes stream through. Files that mix a custom quote character with
+// genuine embedded double quotes aren't representable this way; that's a
+// known limitation of piggy-backing on encoding/csv rather than writing a
+// parser with a configurable quote rune.
+type quoteSwapReader struct {
+	r     io.Reader
+	quote byte
+}
+
+func (q *quoteSwapReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case q.quote:
+			p[i] = '"'
+		case '"':
+			p[i] = q.quote
+		}
+	}
+	return n, err
+}
+
+// newCSVReader builds the csv.Reader for a file, wiring up every parser
+// option exposed on csvConfig: separator, lazy quotes, field-count
+// checking, custom quote character, and source encoding.
+func newCSVReader(cfg *csvConfig, f io.Reader) (*csv.Reader, error) {
+	dec, err := decoderFor(cfg.encoding)
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = f
+	if dec != nil {
+		src = transform.NewReader(src, dec)
+	}
+	if cfg.quote != 0 && cfg.quote != '"' {
+		if cfg.quote > 0xFF {
+			return nil, fmt.Errorf("-quote only supports single-byte quote characters")
+		}
+		src = &quoteSwapReader{r: src, quote: byte(cfg.quote)}
+	}
+
+	r := csv.NewReader(bufio.NewReader(src))
+	r.Comma = cfg.comma
+	r.LazyQuotes = cfg.lazyQuotes
+	r.FieldsPerRecord = cfg.fieldsPerRecord
+	return r, nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// compareMode selects how compareFile gets its two record streams.
+type compareMode string
+
+const (
+	modeMemory       compareMode = "memory"       // original ReadAll + map[string]int approach
+	modeSorted       compareMode = "sorted"       // -assume-sorted: lockstep merge-join, no buffering
+	modeExternalSort compareMode = "external-sort" // -external-sort: spill+merge runs, then merge-join
+)
+
+// externalSortRunSize caps how many records are held in memory at once while
+// spilling sorted runs to disk.
+const externalSortRunSize = 100_000
+
+// idStream yields (id, record) pairs in non-decreasing id order.
+type idStream interface {
+	Next() (id string, rec []string, err error) // err == io.EOF when exhausted
+	Close() error
+}
+
+// streamHeader carries the header metadata a sorted stream needs for
+// resolving id fields and rendering diff messages, mirroring csvFile's
+// header maps without materializing any rows.
+type streamHeader struct {
+	headerToIndex map[string]int
+	indexToHeader map[int]string
+	idFields      []int
+}
+
+func readStreamHeader(cfg *csvConfig, r *csv.Reader, name string) (*streamHeader, error) {
+	headerToIndex := make(map[string]int)
+	indexToHeader := make(map[int]string)
+	if cfg.hasHeader {
+		h, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("can't read header from file %q: %w", name, err)
+		}
+		for i, s := range h {
+			headerToIndex[s] = i
+			indexToHeader[i] = s
+		}
+	}
+	idFields, err := resolveIDFields(cfg, headerToIndex)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve id fields for file %q: %w", name, err)
+	}
+	return &streamHeader{headerToIndex: headerToIndex, indexToHeader: indexToHeader, idFields: idFields}, nil
+}
+
+// directIDStream reads a single already-sorted CSV file in file order and
+// treats it as the id stream directly (used by -assume-sorted).
+type directIDStream struct {
+	f     *os.File
+	r     *csv.Reader
+	hdr   *streamHeader
+	where whereExpr
+
+	hasLast bool
+	lastID  string
+}
+
+func newDirectIDStream(cfg *csvConfig, path string) (*directIDStream, *streamHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open file %q: %w", path, err)
+	}
+	r, err := newCSVReader(cfg, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("can't set up CSV reader for file %q: %w", path, err)
+	}
+	r.ReuseRecord = false
+	hdr, err := readStreamHeader(cfg, r, path)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &directIDStream{f: f, r: r, hdr: hdr, where: cfg.where}, hdr, nil
+}
+
+// Next returns the stream's next (id, record) pair. -assume-sorted trusts
+// the caller's claim that the file is already in id order, but verifies it:
+// an id that sorts before the previous one means that claim is false, and
+// silently continuing would make the merge-join above misreport matching
+// records as separate adds/removes whenever ids aren't in the byte order it
+// assumes (e.g. numeric ids 1, 9, 10 sorted numerically rather than
+// lexically).
+func (d *directIDStream) Next() (string, []string, error) {
+	for {
+		rec, err := d.r.Read()
+		if err != nil {
+			return "", nil, err
+		}
+		if d.where != nil {
+			match, err := d.where.eval(rec, d.hdr.headerToIndex)
+			if err != nil {
+				return "", nil, err
+			}
+			if !match {
+				continue
+			}
+		}
+		id, err := recordKey(rec, d.hdr.idFields)
+		if err != nil {
+			return "", nil, err
+		}
+		if d.hasLast && id < d.lastID {
+			return "", nil, fmt.Errorf(
+				"-assume-sorted requires ids in non-decreasing byte order, but %q follows %q", id, d.lastID,
+			)
+		}
+		d.lastID, d.hasLast = id, true
+		return id, rec, nil
+	}
+}
+
+func (d *directIDStream) Close() error {
+	return d.f.Close()
+}
+
+// spillRun sorts up to externalSortRunSize records by id and writes them to
+// a temp file as id-prefixed CSV rows, returning the run's path.
+func spillRun(cfg *csvConfig, idFields []int, rows [][]string) (string, error) {
+	keys := make([]string, len(rows))
+	for i, rec := range rows {
+		key, err := recordKey(rec, idFields)
+		if err != nil {
+			return "", err
+		}
+		keys[i] = key
+	}
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+
+	tmp, err := os.CreateTemp("", "csv-diff-run-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("can't create temp run file: %w", err)
+	}
+	defer tmp.Close()
+
+	w := csv.NewWriter(tmp)
+	w.Comma = cfg.comma
+	for _, i := range order {
+		row := append([]string{keys[i]}, rows[i]...)
+		if err := w.Write(row); err != nil {
+			return "", fmt.Errorf("can't write temp run file: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", fmt.Errorf("can't flush temp run file: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// runReader reads one spilled, id-sorted run file.
+type runReader struct {
+	f   *os.File
+	r   *csv.Reader
+	cur string
+	rec []string
+	err error
+}
+
+func openRunReader(cfg *csvConfig, path string) (*runReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open run file %q: %w", path, err)
+	}
+	r := csv.NewReader(bufio.NewReader(f))
+	r.Comma = cfg.comma
+	r.FieldsPerRecord = -1 // run files hold whatever widths the source records had
+	rr := &runReader{f: f, r: r}
+	if err := rr.advance(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't read run file %q: %w", path, err)
+	}
+	return rr, nil
+}
+
+// advance reads the next row into rr.cur/rr.rec. A real read error is
+// returned to the caller instead of being folded into end-of-stream; only
+// io.EOF marks the run as exhausted.
+func (rr *runReader) advance() error {
+	row, err := rr.r.Read()
+	if err != nil {
+		rr.err = err
+		rr.cur, rr.rec = "", nil
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	rr.err = nil
+	rr.cur, rr.rec = row[0], row[1:]
+	return nil
+}
+
+func (rr *runReader) close() error {
+	return rr.f.Close()
+}
+
+// mergeIDStream k-way merges the spilled, sorted runs produced by
+// externalSort into a single ascending id stream without ever holding more
+// than one record per run in memory.
+type mergeIDStream struct {
+	runs     []*runReader
+	runPaths []string
+	heap     runHeap
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].cur < h[j].cur }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// externalSort spills path into sorted runs and returns a mergeIDStream over
+// them along with the file's header metadata.
+func externalSort(cfg *csvConfig, path string) (*mergeIDStream, *streamHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	r, err := newCSVReader(cfg, f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't set up CSV reader for file %q: %w", path, err)
+	}
+	hdr, err := readStreamHeader(cfg, r, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var runPaths []string
+	var batch [][]string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		p, err := spillRun(cfg, hdr.idFields, batch)
+		if err != nil {
+			return err
+		}
+		runPaths = append(runPaths, p)
+		batch = nil
+		return nil
+	}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read record from file %q: %w", path, err)
+		}
+		if cfg.where != nil {
+			match, err := cfg.where.eval(rec, hdr.headerToIndex)
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't evaluate -where against file %q: %w", path, err)
+			}
+			if !match {
+				continue
+			}
+		}
+		batch = append(batch, rec)
+		if len(batch) >= externalSortRunSize {
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+
+	runs := make([]*runReader, 0, len(runPaths))
+	for _, p := range runPaths {
+		rr, err := openRunReader(cfg, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		runs = append(runs, rr)
+	}
+
+	h := make(runHeap, 0, len(runs))
+	for _, rr := range runs {
+		if rr.err == nil {
+			h = append(h, rr)
+		}
+	}
+	heap.Init(&h)
+
+	return &mergeIDStream{runs: runs, runPaths: runPaths, heap: h}, hdr, nil
+}
+
+func (m *mergeIDStream) Next() (string, []string, error) {
+	if len(m.heap) == 0 {
+		return "", nil, io.EOF
+	}
+	rr := m.heap[0]
+	id, rec := rr.cur, rr.rec
+	if err := rr.advance(); err != nil {
+		return "", nil, err
+	}
+	if rr.err == io.EOF {
+		heap.Pop(&m.heap)
+	} else {
+		heap.Fix(&m.heap, 0)
+	}
+	return id, rec, nil
+}
+
+func (m *mergeIDStream) Close() error {
+	var firstErr error
+	for _, rr := range m.runs {
+		if err := rr.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, p := range m.runPaths {
+		_ = os.Remove(p)
+	}
+	return firstErr
+}
+
+// streamCompare performs a merge-join diff between two id-ordered streams,
+// advancing the smaller-id side on a mismatch and diffing fields on a
+// matching id. It never materializes either side's full row set, so it can
+// diff inputs far larger than available memory.
+func streamCompare(
+	leftStream, rightStream idStream, leftHdr, rightHdr *streamHeader, policy *columnPolicy, align *headerAlignment,
+	out diffWriter,
+) error {
+	defer leftStream.Close()
+	defer rightStream.Close()
+
+	leftID, leftRec, leftErr := leftStream.Next()
+	rightID, rightRec, rightErr := rightStream.Next()
+
+	addedRecords := 0
+	removedRecords := 0
+	modifiedFields := make(map[string]int)
+	leftRow, rightRow := 0, 0
+
+	for leftErr != io.EOF && rightErr != io.EOF {
+		if leftErr != nil {
+			return fmt.Errorf("can't read left record: %w", leftErr)
+		}
+		if rightErr != nil {
+			return fmt.Errorf("can't read right record: %w", rightErr)
+		}
+		switch {
+		case leftID < rightID:
+			leftRow++
+			removedRecords++
+			out.Removed(leftRow, leftID)
+			leftID, leftRec, leftErr = leftStream.Next()
+		case leftID > rightID:
+			rightRow++
+			addedRecords++
+			out.Added(rightRow, rightID)
+			rightID, rightRec, rightErr = rightStream.Next()
+		default:
+			leftRow++
+			rightRow++
+			var changes []fieldChange
+			if align != nil {
+				var incompatible bool
+				changes, incompatible = diffAlignedFields(leftRec, rightRec, align, modifiedFields, policy)
+				if incompatible {
+					out.Incompatible(leftRow, rightRow, leftID, len(leftRec), len(rightRec))
+				}
+			} else if len(leftRec) != len(rightRec) {
+				out.Incompatible(leftRow, rightRow, leftID, len(leftRec), len(rightRec))
+			} else {
+				changes = diffRecordFields(leftRec, rightRec, leftHdr.indexToHeader, rightHdr.indexToHeader, modifiedFields, policy)
+			}
+			if len(changes) > 0 {
+				out.Modified(leftRow, rightRow, leftID, changes)
+			}
+			leftID, leftRec, leftErr = leftStream.Next()
+			rightID, rightRec, rightErr = rightStream.Next()
+		}
+	}
+	for leftErr != io.EOF {
+		if leftErr != nil {
+			return fmt.Errorf("can't read left record: %w", leftErr)
+		}
+		leftRow++
+		removedRecords++
+		out.Removed(leftRow, leftID)
+		leftID, leftRec, leftErr = leftStream.Next()
+	}
+	for rightErr != io.EOF {
+		if rightErr != nil {
+			return fmt.Errorf("can't read right record: %w", rightErr)
+		}
+		rightRow++
+		addedRecords++
+		out.Added(rightRow, rightID)
+		rightID, rightRec, rightErr = rightStream.Next()
+	}
+
+	return out.Summary(addedRecords, removedRecords, modifiedFields)
+}
+
+// compareFileStreaming dispatches to the assume-sorted or external-sort
+// streaming path, as selected by mode.
+func compareFileStreaming(cfg *csvConfig, mode compareMode, leftPath, rightPath string, out diffWriter) error {
+	var leftStream, rightStream idStream
+	var leftHdr, rightHdr *streamHeader
+	var err error
+
+	switch mode {
+	case modeSorted:
+		leftStream, leftHdr, err = newDirectIDStream(cfg, leftPath)
+		if err != nil {
+			return err
+		}
+		rightStream, rightHdr, err = newDirectIDStream(cfg, rightPath)
+		if err != nil {
+			return err
+		}
+	case modeExternalSort:
+		leftStream, leftHdr, err = externalSort(cfg, leftPath)
+		if err != nil {
+			return err
+		}
+		rightStream, rightHdr, err = externalSort(cfg, rightPath)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown streaming compare mode %q", mode)
+	}
+
+	policy, err := newColumnPolicy(cfg, leftHdr.headerToIndex)
+	if err != nil {
+		return err
+	}
+	var align *headerAlignment
+	if cfg.alignHeaders {
+		align = buildAlignment(leftHdr.headerToIndex, rightHdr.headerToIndex, cfg.renames)
+		reportSchemaAlignment(align, leftHdr.indexToHeader, rightHdr.indexToHeader, policy, out)
+	}
+
+	return streamCompare(leftStream, rightStream, leftHdr, rightHdr, policy, align, out)
+}
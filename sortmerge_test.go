@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/heap"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSpillRunSortsById(t *testing.T) {
+	cfg := &csvConfig{comma: '|'}
+	rows := [][]string{
+		{"3", "c"},
+		{"1", "a"},
+		{"2", "b"},
+	}
+	path, err := spillRun(cfg, []int{0}, rows)
+	if err != nil {
+		t.Fatalf("spillRun: %v", err)
+	}
+	defer os.Remove(path)
+
+	rr, err := openRunReader(cfg, path)
+	if err != nil {
+		t.Fatalf("openRunReader: %v", err)
+	}
+	defer rr.close()
+
+	var ids []string
+	for rr.err != io.EOF {
+		ids = append(ids, rr.cur)
+		if err := rr.advance(); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+	want := []string{"1", "2", "3"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("run order = %v, want %v", ids, want)
+	}
+}
+
+func TestSpillRunRejectsOutOfRangeIDField(t *testing.T) {
+	cfg := &csvConfig{comma: '|'}
+	rows := [][]string{{"only-one-field"}}
+	if _, err := spillRun(cfg, []int{1}, rows); err == nil {
+		t.Error("expected spillRun to reject an id field index out of range")
+	}
+}
+
+// TestMergeIDStreamAcrossRuns checks the k-way merge boundary between two
+// spilled runs: interleaved ids from different runs must still come out in
+// a single ascending sequence.
+func TestMergeIDStreamAcrossRuns(t *testing.T) {
+	cfg := &csvConfig{comma: '|'}
+	runA, err := spillRun(cfg, []int{0}, [][]string{{"1", "a"}, {"4", "d"}})
+	if err != nil {
+		t.Fatalf("spillRun runA: %v", err)
+	}
+	defer os.Remove(runA)
+	runB, err := spillRun(cfg, []int{0}, [][]string{{"2", "b"}, {"3", "c"}})
+	if err != nil {
+		t.Fatalf("spillRun runB: %v", err)
+	}
+	defer os.Remove(runB)
+
+	rrA, err := openRunReader(cfg, runA)
+	if err != nil {
+		t.Fatalf("openRunReader runA: %v", err)
+	}
+	rrB, err := openRunReader(cfg, runB)
+	if err != nil {
+		t.Fatalf("openRunReader runB: %v", err)
+	}
+
+	h := make(runHeap, 0, 2)
+	for _, rr := range []*runReader{rrA, rrB} {
+		if rr.err == nil {
+			h = append(h, rr)
+		}
+	}
+	heap.Init(&h)
+	m := &mergeIDStream{runs: []*runReader{rrA, rrB}, runPaths: []string{runA, runB}, heap: h}
+	defer m.Close()
+
+	var ids []string
+	for {
+		id, _, err := m.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	want := []string{"1", "2", "3", "4"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("merged order = %v, want %v", ids, want)
+	}
+}
+
+// TestRunReaderPropagatesRealErrors guards against folding a real CSV parse
+// error into end-of-stream, which would silently drop every record after it.
+func TestRunReaderPropagatesRealErrors(t *testing.T) {
+	tmp, err := os.CreateTemp("", "csv-diff-run-test-*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString("1,a,b\n2,\"unterminated\n"); err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	cfg := &csvConfig{comma: ','}
+	rr, err := openRunReader(cfg, tmp.Name())
+	if err != nil {
+		t.Fatalf("openRunReader: %v", err)
+	}
+	defer rr.close()
+
+	if rr.cur != "1" {
+		t.Fatalf("first row id = %q, want %q", rr.cur, "1")
+	}
+	if err := rr.advance(); err == nil {
+		t.Fatal("expected a parse error advancing past the malformed row, got nil")
+	} else if err == io.EOF {
+		t.Fatal("malformed row was swallowed as io.EOF instead of surfaced as a real error")
+	}
+}
+
+func TestRunReaderReachesCleanEOF(t *testing.T) {
+	tmp, err := os.CreateTemp("", "csv-diff-run-test-*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString("1,a\n2,b\n"); err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	cfg := &csvConfig{comma: ','}
+	rr, err := openRunReader(cfg, tmp.Name())
+	if err != nil {
+		t.Fatalf("openRunReader: %v", err)
+	}
+	defer rr.close()
+
+	if err := rr.advance(); err != nil {
+		t.Fatalf("advance to second row: %v", err)
+	}
+	if rr.cur != "2" {
+		t.Fatalf("second row id = %q, want %q", rr.cur, "2")
+	}
+	if err := rr.advance(); err != nil {
+		t.Fatalf("advance past end: %v", err)
+	}
+	if rr.err != io.EOF {
+		t.Fatalf("rr.err = %v, want io.EOF", rr.err)
+	}
+}
+
+func TestDirectIDStreamDetectsOutOfOrderIDs(t *testing.T) {
+	tmp, err := os.CreateTemp("", "csv-diff-direct-test-*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString("id|name\n1|a\n9|b\n10|c\n"); err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	cfg := &csvConfig{hasHeader: true, comma: '|', idFields: []string{"id"}, fieldsPerRecord: -1}
+	stream, _, err := newDirectIDStream(cfg, tmp.Name())
+	if err != nil {
+		t.Fatalf("newDirectIDStream: %v", err)
+	}
+	defer stream.Close()
+
+	if _, _, err := stream.Next(); err != nil {
+		t.Fatalf("first Next: %v", err)
+	}
+	if _, _, err := stream.Next(); err != nil {
+		t.Fatalf("second Next: %v", err)
+	}
+	// "10" sorts lexically before "9", violating the claimed order.
+	if _, _, err := stream.Next(); err == nil {
+		t.Error("expected an error for the lexically-earlier id \"10\" following \"9\"")
+	}
+}